@@ -8,10 +8,24 @@ package main
 
 import (
 	"bufio"
+	"container/heap"
+	"context"
+	"encoding/binary"
 	"flag"
 	"fmt"
+	"io"
+	"math/bits"
 	"os"
+	"regexp"
+	"runtime"
+	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
+
+	"golang.org/x/sync/errgroup"
+	"golang.org/x/text/cases"
+	"golang.org/x/text/language"
 )
 
 type Word string
@@ -25,27 +39,197 @@ type WordPair struct {
 	b Word
 }
 
-type WordMask uint64
+// Mask is an arbitrary-width bitset, one bit per distinct rune, with no
+// ceiling on alphabet size (unlike a single machine word).
+type Mask []uint64
+
+// Union returns the bitwise union of two same-length masks
+func (m Mask) Union(other Mask) Mask {
+	union := make(Mask, len(m))
+	for i := range m {
+		union[i] = m[i] | other[i]
+	}
+	return union
+}
+
+// Weight returns the number of set bits, i.e. the amount of unique characters
+func (m Mask) Weight() int {
+	weight := 0
+	for _, word := range m {
+		weight += bits.OnesCount64(word)
+	}
+	return weight
+}
+
+// key returns a byte string uniquely identifying the mask's bits, suitable
+// as a map key for hash-consing identical masks
+func (m Mask) key() string {
+	buf := make([]byte, len(m)*8)
+	for i, word := range m {
+		binary.LittleEndian.PutUint64(buf[i*8:], word)
+	}
+	return string(buf)
+}
+
+// MaskID is a small handle into a maskTable, used everywhere a mask needs to
+// be compared, hashed, or stored instead of carrying its full Mask around.
+type MaskID uint32
+
+// maskTable hash-conses Masks so identical masks share storage and are
+// addressed by a 4-byte MaskID, and caches unions of MaskID pairs so the same
+// union is never recomputed.
+type maskTable struct {
+	mu      sync.Mutex
+	masks   []Mask
+	weights []WordMaskWeight
+	byKey   map[string]MaskID
+	unions  map[[2]MaskID]MaskID
+}
+
+func newMaskTable() *maskTable {
+	return &maskTable{
+		byKey:  make(map[string]MaskID),
+		unions: make(map[[2]MaskID]MaskID),
+	}
+}
+
+// intern returns the MaskID for mask, assigning it a fresh one if this is the
+// first time an identical mask has been seen
+func (t *maskTable) intern(mask Mask) MaskID {
+	key := mask.key()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if id, ok := t.byKey[key]; ok {
+		return id
+	}
+	id := MaskID(len(t.masks))
+	t.masks = append(t.masks, mask)
+	t.weights = append(t.weights, WordMaskWeight(mask.Weight()))
+	t.byKey[key] = id
+	return id
+}
+
+// union returns the MaskID of a.Union(b), computing and interning it only
+// the first time this pair of ids is unioned
+func (t *maskTable) union(a, b MaskID) MaskID {
+	if a == b {
+		return a
+	}
+	pairKey := [2]MaskID{a, b}
+	if a > b {
+		pairKey[0], pairKey[1] = b, a
+	}
+
+	t.mu.Lock()
+	if id, ok := t.unions[pairKey]; ok {
+		t.mu.Unlock()
+		return id
+	}
+	maskA, maskB := t.masks[a], t.masks[b]
+	t.mu.Unlock()
+
+	id := t.intern(maskA.Union(maskB))
+
+	t.mu.Lock()
+	t.unions[pairKey] = id
+	t.mu.Unlock()
+	return id
+}
+
+func (t *maskTable) weight(id MaskID) WordMaskWeight {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.weights[id]
+}
+
+// mask returns the raw Mask backing id. Masks are never mutated in place
+// once interned, so a caller that snapshots the result (as topPairsAndWeight
+// does, once, before sharding work across workers) may read it afterwards
+// without holding t.mu.
+func (t *maskTable) mask(id MaskID) Mask {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.masks[id]
+}
 
 type WordMaskPair struct {
-	a WordMask
-	b WordMask
+	a MaskID
+	b MaskID
+}
+
+type WordMaskWeight int
+
+type ScoredMaskPair struct {
+	pair   WordMaskPair
+	weight WordMaskWeight
 }
 
-type WordMaskWeight uint8
+// A bounded min-heap of ScoredMaskPair, ordered by weight, used to keep the
+// K best pairs seen so far in TopKPairs.
+type scoredMaskPairHeap []ScoredMaskPair
+
+func (h scoredMaskPairHeap) Len() int            { return len(h) }
+func (h scoredMaskPairHeap) Less(i, j int) bool  { return h[i].weight < h[j].weight }
+func (h scoredMaskPairHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *scoredMaskPairHeap) Push(x interface{}) { *h = append(*h, x.(ScoredMaskPair)) }
+func (h *scoredMaskPairHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	item := old[n-1]
+	*h = old[:n-1]
+	return item
+}
 
 type WordSetMasks struct {
-	words WordSet
-	wordsByMasks map[WordMask][]Word
-	wordMasksByWeight map[WordMaskWeight][]WordMask
+	words             WordSet
+	maskTable         *maskTable
+	emptyMaskID       MaskID
+	wordsByMasks      map[MaskID][]Word
+	wordMasksByWeight map[WordMaskWeight][]MaskID
 }
 
 func main() {
 	filename := flag.String("f", "alastalon_salissa.txt", "source file")
 	whitelistChars := flag.String("c", "abcdefghijklmnopqrstuvwzyxåäö", "handled characters")
+	topK := flag.Int("k", 5, "number of top-weighted pairs to list")
+	tupleN := flag.Int("n", 0, "find the top N-word tuple by weight (N >= 2, 0 to disable)")
+	workers := flag.Int("j", 0, "number of workers for the pair search (0 = GOMAXPROCS)")
+	fieldSep := flag.String("F", "", "regex field separator for tokenizing (default: whitespace)")
+	unicodeFold := flag.Bool("unicode", false, "fold case with Unicode-aware rules instead of ASCII lower")
+	invalidPolicy := flag.String("invalid", "drop", "policy for runes outside -c: drop, split, or skip-word")
 	flag.Parse()
 
-	words, err := readUniqWordsFromFile(*filename, *whitelistChars)
+	switch *invalidPolicy {
+	case "drop", "split", "skip-word":
+	default:
+		fmt.Fprintf(os.Stderr, "invalid -invalid policy %q: must be drop, split, or skip-word\n", *invalidPolicy)
+		os.Exit(1)
+	}
+
+	var sep *regexp.Regexp
+	split := splitWhitespace
+	if *fieldSep != "" {
+		var err error
+		sep, err = regexp.Compile(*fieldSep)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		split = func(r io.Reader) ([]string, error) { return splitRegexFields(r, sep) }
+	}
+
+	var tokenizer Tokenizer
+	switch {
+	case *unicodeFold:
+		tokenizer = UnicodeTokenizer{split: split}
+	case sep != nil:
+		tokenizer = RegexFieldTokenizer{sep: sep}
+	default:
+		tokenizer = WhitespaceTokenizer{}
+	}
+
+	words, err := readUniqWordsFromFile(*filename, *whitelistChars, tokenizer, *invalidPolicy)
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
@@ -59,7 +243,7 @@ func main() {
 	fmt.Printf("            Unique sets of chars: %d\n", len(wsm.wordsByMasks))
 
 	// calculate plz
-	topMasks, topWeight := wsm.topPairsAndWeight()
+	topMasks, topWeight := wsm.topPairsAndWeight(*workers)
 
 	fmt.Println()
 	fmt.Printf(" Top pairs found (weight %d)\n", topWeight)
@@ -67,6 +251,34 @@ func main() {
 	for _, pair := range wsm.maskPairsToWordPairs(topMasks) {
 		fmt.Printf("%s %s\n", pair.a, pair.b)
 	}
+
+	if *topK > 0 {
+		scoredPairs := wsm.TopKPairs(*topK)
+
+		fmt.Println()
+		fmt.Printf(" Top %d pairs by weight\n", *topK)
+		fmt.Printf("-----------------------------\n")
+		for rank, scored := range scoredPairs {
+			for _, pair := range wsm.maskPairsToWordPairs([]WordMaskPair{scored.pair}) {
+				fmt.Printf("%2d. %s %s (weight %d)\n", rank+1, pair.a, pair.b, scored.weight)
+			}
+		}
+	}
+
+	if *tupleN >= 2 {
+		_, tupleWords, tupleWeight := wsm.TopTuplesAndWeight(*tupleN)
+
+		fmt.Println()
+		fmt.Printf(" Top %d-tuple found (weight %d)\n", *tupleN, tupleWeight)
+		fmt.Printf("-----------------------------\n")
+		for _, words := range tupleWords {
+			strs := make([]string, len(words))
+			for i, word := range words {
+				strs[i] = string(word)
+			}
+			fmt.Println(strings.Join(strs, " "))
+		}
+	}
 }
 
 // WordSetMasks
@@ -74,37 +286,48 @@ func main() {
 
 // Create a helper structure for calculating the weights
 func NewWordSetMasks(words WordSet) *WordSetMasks {
-	// create a map with which to create the word masks
-	runeMaskMap := make(map[rune]WordMask)
+	// assign every distinct rune a bit position, however many bits that takes
+	uniqRunes := wordListUniqRunes(words)
+	runeBitIndex := make(map[rune]int, len(uniqRunes))
 	i := 0
-	for rune := range wordListUniqRunes(words) {
-		runeMaskMap[rune] = 1 << WordMask(i)
+	for rune := range uniqRunes {
+		runeBitIndex[rune] = i
 		i = i + 1
 	}
+	numMaskWords := (len(uniqRunes) + 63) / 64
+	if numMaskWords == 0 {
+		numMaskWords = 1
+	}
+
+	table := newMaskTable()
+	emptyMaskID := table.intern(make(Mask, numMaskWords))
 
 	// group words by their masks
-	wordsByMasks := make(map[WordMask][]Word)
+	wordsByMasks := make(map[MaskID][]Word)
 	for word := range words {
-		mask := word.mask(runeMaskMap)
-		if _, ok := wordsByMasks[mask]; !ok {
-			wordsByMasks[mask] = make([]Word, 0)
+		mask := word.mask(runeBitIndex, numMaskWords)
+		id := table.intern(mask)
+		if _, ok := wordsByMasks[id]; !ok {
+			wordsByMasks[id] = make([]Word, 0)
 		}
-		wordsByMasks[mask] = append(wordsByMasks[mask], word)
+		wordsByMasks[id] = append(wordsByMasks[id], word)
 	}
 
 	// group masks by their weights
-	wordMasksByWeight := make(map[WordMaskWeight][]WordMask, 0)
-	for mask := range wordsByMasks {
-		weight := mask.weight()
+	wordMasksByWeight := make(map[WordMaskWeight][]MaskID, 0)
+	for id := range wordsByMasks {
+		weight := table.weight(id)
 		if _, ok := wordMasksByWeight[weight]; !ok {
-			wordMasksByWeight[weight] = make([]WordMask, 0)
+			wordMasksByWeight[weight] = make([]MaskID, 0)
 		}
-		wordMasksByWeight[weight] = append(wordMasksByWeight[weight], mask)
+		wordMasksByWeight[weight] = append(wordMasksByWeight[weight], id)
 	}
 
 	return &WordSetMasks{
-		words: words,
-		wordsByMasks: wordsByMasks,
+		words:             words,
+		maskTable:         table,
+		emptyMaskID:       emptyMaskID,
+		wordsByMasks:      wordsByMasks,
 		wordMasksByWeight: wordMasksByWeight,
 	}
 }
@@ -122,73 +345,428 @@ func (wsm WordSetMasks) maskPairsToWordPairs(maskPairs []WordMaskPair) []WordPai
 	return wordPairs
 }
 
-// Find mask pairs that have the most weight (most uniq chars)
-func (wsm WordSetMasks) topPairsAndWeight() ([]WordMaskPair, WordMaskWeight) {
+// Find mask pairs that have the most weight (most uniq chars).
+//
+// The outer mask is sharded across `workers` goroutines (GOMAXPROCS if <= 0)
+// via an index channel. Each worker keeps its own local topWeight/topPairs
+// to avoid contention on the hot path, consulting a shared atomic topWeight
+// only to prune; results are merged under a mutex once every worker is done.
+// The per-pair union+popcount itself never touches maskTable or its mutex:
+// every mask's raw bits are snapshotted once up front (masks are immutable
+// once interned), so workers compute unions directly off that snapshot.
+func (wsm WordSetMasks) topPairsAndWeight(workers int) ([]WordMaskPair, WordMaskWeight) {
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+
+	allMasks := make([]MaskID, 0, len(wsm.wordsByMasks))
+	allWeights := make([]WordMaskWeight, 0, len(wsm.wordsByMasks))
+	allRawMasks := make([]Mask, 0, len(wsm.wordsByMasks))
+	for id := range wsm.wordsByMasks {
+		allMasks = append(allMasks, id)
+		allWeights = append(allWeights, wsm.maskTable.weight(id))
+		allRawMasks = append(allRawMasks, wsm.maskTable.mask(id))
+	}
+
+	var globalTopWeight uint32 // atomic, mirrors the best weight found so far
+	var mergeMutex sync.Mutex
 	topWeight := WordMaskWeight(0)
 	var topPairs []WordMaskPair
-	checkedMasks := make(map[WordMask]struct{}, len(wsm.wordsByMasks))
+
+	indexes := make(chan int)
+	group, _ := errgroup.WithContext(context.Background())
+	for w := 0; w < workers; w++ {
+		group.Go(func() error {
+			localTopWeight := WordMaskWeight(0)
+			var localTopPairs []WordMaskPair
+			for i := range indexes {
+				iMask, iWeight, iRaw := allMasks[i], allWeights[i], allRawMasks[i]
+				for j := i + 1; j < len(allMasks); j++ {
+					heapMin := WordMaskWeight(atomic.LoadUint32(&globalTopWeight))
+					if iWeight+allWeights[j] < heapMin {
+						continue // this pair cannot win
+					}
+					pairWeight := WordMaskWeight(iRaw.Union(allRawMasks[j]).Weight())
+					if pairWeight > localTopWeight {
+						localTopWeight = pairWeight
+						localTopPairs = localTopPairs[:0]
+					}
+					if pairWeight == localTopWeight {
+						localTopPairs = append(localTopPairs, WordMaskPair{iMask, allMasks[j]})
+					}
+					raiseGlobalTopWeight(&globalTopWeight, uint32(pairWeight))
+				}
+			}
+
+			mergeMutex.Lock()
+			defer mergeMutex.Unlock()
+			if localTopWeight > topWeight {
+				topWeight = localTopWeight
+				topPairs = append(topPairs[:0], localTopPairs...)
+			} else if localTopWeight == topWeight {
+				topPairs = append(topPairs, localTopPairs...)
+			}
+			return nil
+		})
+	}
+
+	go func() {
+		defer close(indexes)
+		for i := range allMasks {
+			indexes <- i
+		}
+	}()
+
+	group.Wait() // workers never return an error
+
+	return topPairs, topWeight
+}
+
+// Atomically raise addr to value if value is greater than its current contents
+func raiseGlobalTopWeight(addr *uint32, value uint32) {
+	for {
+		current := atomic.LoadUint32(addr)
+		if value <= current {
+			return
+		}
+		if atomic.CompareAndSwapUint32(addr, current, value) {
+			return
+		}
+	}
+}
+
+// Find the K distinct mask pairs with the highest weight, sorted descending
+// by weight and, for ties, lexicographically by their expanded words.
+func (wsm WordSetMasks) TopKPairs(k int) []ScoredMaskPair {
+	h := &scoredMaskPairHeap{}
+	heapMin := WordMaskWeight(0)
+	checkedMasks := make(map[MaskID]struct{}, len(wsm.wordsByMasks))
 	for iWeight, iMasks := range wsm.wordMasksByWeight {
 		for _, iMask := range iMasks {
 			checkedMasks[iMask] = struct{}{}
 			for jWeight, jMasks := range wsm.wordMasksByWeight {
-				if iWeight + jWeight < topWeight {
-					continue // this weight combination cannot win
+				if iWeight+jWeight < heapMin {
+					continue // this weight combination cannot make the heap
 				}
 				for _, jMask := range jMasks {
 					if _, ok := checkedMasks[jMask]; ok {
 						continue // mask already checked
 					}
-					pairMask := iMask.union(jMask)
-					pairWeight := pairMask.weight()
-					if pairWeight > topWeight {
-						topWeight = pairWeight
-						topPairs = make([]WordMaskPair, 0)
-					}
-					if pairWeight == topWeight {
-						topPairs = append(topPairs, WordMaskPair{iMask,jMask})
+					pairWeight := wsm.maskTable.weight(wsm.maskTable.union(iMask, jMask))
+					if h.Len() < k {
+						heap.Push(h, ScoredMaskPair{WordMaskPair{iMask, jMask}, pairWeight})
+						if h.Len() == k {
+							heapMin = (*h)[0].weight
+						}
+					} else if pairWeight > (*h)[0].weight {
+						(*h)[0] = ScoredMaskPair{WordMaskPair{iMask, jMask}, pairWeight}
+						heap.Fix(h, 0)
+						heapMin = (*h)[0].weight
 					}
 				}
 			}
 		}
 	}
-	return topPairs, topWeight
+
+	scoredPairs := make([]ScoredMaskPair, h.Len())
+	copy(scoredPairs, *h)
+	sort.Slice(scoredPairs, func(i, j int) bool {
+		if scoredPairs[i].weight != scoredPairs[j].weight {
+			return scoredPairs[i].weight > scoredPairs[j].weight
+		}
+		aWordI, bWordI := wsm.leastWord(scoredPairs[i].pair.a), wsm.leastWord(scoredPairs[i].pair.b)
+		aWordJ, bWordJ := wsm.leastWord(scoredPairs[j].pair.a), wsm.leastWord(scoredPairs[j].pair.b)
+		if aWordI != aWordJ {
+			return aWordI < aWordJ
+		}
+		return bWordI < bWordJ
+	})
+	return scoredPairs
+}
+
+// Return the lexicographically smallest word mapping to a mask
+func (wsm WordSetMasks) leastWord(mask MaskID) Word {
+	words := wsm.wordsByMasks[mask]
+	least := words[0]
+	for _, word := range words[1:] {
+		if word < least {
+			least = word
+		}
+	}
+	return least
+}
+
+// A tuple of word masks, one per word in the combination
+type WordMaskTuple []MaskID
+
+// A combination of masks along with their pre-computed union and weight.
+// boundary is the index (into the allMasks slice a combo was built from)
+// that separates it from its counterpart half: for a left-half combo it is
+// the largest index used, for a right-half combo the smallest. Two halves
+// only ever represent distinct words if left.boundary < right.boundary.
+type maskCombo struct {
+	masks    WordMaskTuple
+	union    MaskID
+	weight   WordMaskWeight
+	boundary int
+}
+
+// Find the N-word tuple (N >= 2) maximizing unique characters, using a
+// meet-in-the-middle search: every N-combination of masks is split at a
+// single point into a left and right half (the leftSize smallest indices
+// and the rightSize largest), so halves built this way can never reuse the
+// same mask. Each half is precomputed once and the halves are joined under
+// a branch-and-bound weight cutoff.
+func (wsm WordSetMasks) TopTuplesAndWeight(n int) ([]WordMaskTuple, [][]Word, WordMaskWeight) {
+	if n < 2 {
+		n = 2
+	}
+	leftSize := (n + 1) / 2
+	rightSize := n / 2
+
+	allMasks := make([]MaskID, 0, len(wsm.wordsByMasks))
+	for mask := range wsm.wordsByMasks {
+		allMasks = append(allMasks, mask)
+	}
+
+	leftCombos := wsm.combineMasksByUnion(allMasks, leftSize, lastIndex)
+	rightCombos := wsm.combineMasksByUnion(allMasks, rightSize, firstIndex)
+	sort.Slice(rightCombos, func(i, j int) bool {
+		return rightCombos[i].weight > rightCombos[j].weight
+	})
+
+	// rightMaxFrom[i] is the highest weight among rightCombos[i:], so a left
+	// half can be abandoned as soon as even the best remaining right half
+	// cannot beat the current top. It's a loose bound once boundary-invalid
+	// rights are skipped below, but it's still a sound upper bound.
+	rightMaxFrom := make([]WordMaskWeight, len(rightCombos)+1)
+	for i := len(rightCombos) - 1; i >= 0; i-- {
+		rightMaxFrom[i] = rightMaxFrom[i+1]
+		if rightCombos[i].weight > rightMaxFrom[i] {
+			rightMaxFrom[i] = rightCombos[i].weight
+		}
+	}
+
+	leftByWeight := make(map[WordMaskWeight][]maskCombo)
+	for _, combo := range leftCombos {
+		leftByWeight[combo.weight] = append(leftByWeight[combo.weight], combo)
+	}
+	leftWeights := make([]WordMaskWeight, 0, len(leftByWeight))
+	for weight := range leftByWeight {
+		leftWeights = append(leftWeights, weight)
+	}
+	sort.Slice(leftWeights, func(i, j int) bool { return leftWeights[i] > leftWeights[j] })
+
+	topWeight := WordMaskWeight(0)
+	var topTuples []WordMaskTuple
+	for _, leftWeight := range leftWeights {
+		if len(rightMaxFrom) > 0 && leftWeight+rightMaxFrom[0] < topWeight {
+			break // leftWeights is sorted descending, so no smaller leftWeight can win either
+		}
+		for _, left := range leftByWeight[leftWeight] {
+			for idx, right := range rightCombos {
+				if left.weight+rightMaxFrom[idx] < topWeight {
+					break // right combos are sorted descending, none after this can win either
+				}
+				if right.boundary <= left.boundary {
+					continue // would reuse a mask already claimed by the left half
+				}
+				tupleWeight := wsm.maskTable.weight(wsm.maskTable.union(left.union, right.union))
+				if tupleWeight > topWeight {
+					topWeight = tupleWeight
+					topTuples = make([]WordMaskTuple, 0)
+				}
+				if tupleWeight == topWeight {
+					tuple := make(WordMaskTuple, 0, len(left.masks)+len(right.masks))
+					tuple = append(tuple, left.masks...)
+					tuple = append(tuple, right.masks...)
+					topTuples = append(topTuples, tuple)
+				}
+			}
+		}
+	}
+
+	return topTuples, wsm.maskTuplesToWordTuples(topTuples), topWeight
+}
+
+// lastIndex and firstIndex pick the boundary for a left-half and a
+// right-half maskCombo respectively (see maskCombo.boundary). indices is
+// always in strictly increasing order.
+func lastIndex(indices []int) int  { return indices[len(indices)-1] }
+func firstIndex(indices []int) int { return indices[0] }
+
+// Enumerate every combination of `size` distinct mask indices from `masks`
+// (in strictly increasing index order), keeping only the first combination
+// found for each distinct (union mask, boundary) pair so that equivalent
+// half-tuples are not expanded more than once. boundary derives the value
+// used to keep two halves index-disjoint (see maskCombo.boundary) from the
+// indices chosen for this combo.
+func (wsm WordSetMasks) combineMasksByUnion(masks []MaskID, size int, boundary func(indices []int) int) []maskCombo {
+	type comboKey struct {
+		union    MaskID
+		boundary int
+	}
+	seen := make(map[comboKey]struct{})
+	combos := make([]maskCombo, 0)
+	current := make([]int, 0, size)
+
+	var recurse func(start int)
+	recurse = func(start int) {
+		if len(current) == size {
+			union := wsm.emptyMaskID
+			tuple := make(WordMaskTuple, size)
+			for i, idx := range current {
+				union = wsm.maskTable.union(union, masks[idx])
+				tuple[i] = masks[idx]
+			}
+			key := comboKey{union, boundary(current)}
+			if _, ok := seen[key]; ok {
+				return // mask combination already expanded
+			}
+			seen[key] = struct{}{}
+			combos = append(combos, maskCombo{
+				masks:    tuple,
+				union:    union,
+				weight:   wsm.maskTable.weight(union),
+				boundary: key.boundary,
+			})
+			return
+		}
+		for i := start; i <= len(masks)-(size-len(current)); i++ {
+			current = append(current, i)
+			recurse(i + 1)
+			current = current[:len(current)-1]
+		}
+	}
+	recurse(0)
+	return combos
+}
+
+// Convert mask-tuples to word-tuples, expanding each component mask to
+// every word sharing it (the Cartesian product across tuple positions)
+func (wsm WordSetMasks) maskTuplesToWordTuples(tuples []WordMaskTuple) [][]Word {
+	wordTuples := make([][]Word, 0)
+	for _, tuple := range tuples {
+		wordsByPosition := make([][]Word, len(tuple))
+		for i, mask := range tuple {
+			wordsByPosition[i] = wsm.wordsByMasks[mask]
+		}
+		wordTuples = append(wordTuples, cartesianWords(wordsByPosition)...)
+	}
+	return wordTuples
+}
+
+// Compute the Cartesian product of word lists, one list per tuple position
+func cartesianWords(wordsByPosition [][]Word) [][]Word {
+	products := [][]Word{{}}
+	for _, words := range wordsByPosition {
+		next := make([][]Word, 0, len(products)*len(words))
+		for _, prefix := range products {
+			for _, word := range words {
+				product := make([]Word, len(prefix)+1)
+				copy(product, prefix)
+				product[len(prefix)] = word
+				next = append(next, product)
+			}
+		}
+		products = next
+	}
+	return products
 }
 
 // Word
 //
 
-// Calculate a mask for word given a rune mask map
-func (word Word) mask(runeMaskMap map[rune]WordMask) WordMask {
-	mask := WordMask(0)
+// Calculate a mask for word given a rune-to-bit-position index
+func (word Word) mask(runeBitIndex map[rune]int, numMaskWords int) Mask {
+	mask := make(Mask, numMaskWords)
 	for _, rune := range word {
-		mask = mask | runeMaskMap[rune]
+		bit := runeBitIndex[rune]
+		mask[bit/64] |= 1 << uint(bit%64)
 	}
 	return mask
 }
 
-// WordMask
+// Tokenizer
 //
 
-// Create an union wordmask from two masks
-func (wm WordMask) union(other WordMask) WordMask {
-	return wm | other
+// Tokenizer splits raw input into word tokens, already case-folded. Which
+// runes a token contains decides whether it passes the -c whitelist, so the
+// choice of tokenizer (in particular, ASCII vs. Unicode folding) directly
+// shapes the mask alphabet NewWordSetMasks builds.
+type Tokenizer interface {
+	Tokens(r io.Reader) ([]string, error)
 }
 
-// Calculate the weight of the word mask
-// Weight is the amount of unique characters
-// http://en.wikipedia.org/wiki/Hamming_weight
-func (wm WordMask) weight() WordMaskWeight {
-	mask1 := uint64(6148914691236517205) // 01010101...
-	mask2 := uint64(3689348814741910323) // 00110011...
-	mask4 := uint64(1085102592571150095) // 00001111...
-	x := uint64(wm)
-	x = x - ((x >> 1) & mask1)
-	x = (x & mask2) + ((x >> 2) & mask2)
-	x = (x + (x >> 4)) & mask4
-	x = x + (x >> 8)
-	x = x + (x >> 16)
-	x = x + (x >> 32)
-	return WordMaskWeight(x)
+// WhitespaceTokenizer splits on runs of whitespace, ASCII-lowercasing each
+// token. This is the original, default behavior.
+type WhitespaceTokenizer struct{}
+
+func (WhitespaceTokenizer) Tokens(r io.Reader) ([]string, error) {
+	raw, err := splitWhitespace(r)
+	return foldEach(raw, strings.ToLower), err
+}
+
+// RegexFieldTokenizer splits input on a regular expression field separator,
+// awk -F style, ASCII-lowercasing each token.
+type RegexFieldTokenizer struct {
+	sep *regexp.Regexp
+}
+
+func (t RegexFieldTokenizer) Tokens(r io.Reader) ([]string, error) {
+	raw, err := splitRegexFields(r, t.sep)
+	return foldEach(raw, strings.ToLower), err
+}
+
+// UnicodeTokenizer wraps a raw splitting function and case-folds its tokens
+// with locale-independent Unicode rules instead of ASCII strings.ToLower, so
+// that e.g. "İ" and "ẞ" fold the same way regardless of the host locale.
+type UnicodeTokenizer struct {
+	split func(r io.Reader) ([]string, error)
+}
+
+func (t UnicodeTokenizer) Tokens(r io.Reader) ([]string, error) {
+	raw, err := t.split(r)
+	caser := cases.Lower(language.Und)
+	return foldEach(raw, caser.String), err
+}
+
+// Fold every token with fold
+func foldEach(tokens []string, fold func(string) string) []string {
+	folded := make([]string, len(tokens))
+	for i, token := range tokens {
+		folded[i] = fold(token)
+	}
+	return folded
+}
+
+// Split r into raw (unfolded) whitespace-separated tokens
+func splitWhitespace(r io.Reader) ([]string, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Split(bufio.ScanWords)
+
+	var tokens []string
+	for scanner.Scan() {
+		tokens = append(tokens, scanner.Text())
+	}
+	return tokens, scanner.Err()
+}
+
+// Split r into raw (unfolded) tokens separated by the regex sep, awk -F style
+func splitRegexFields(r io.Reader, sep *regexp.Regexp) ([]string, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var tokens []string
+	for _, field := range sep.Split(string(data), -1) {
+		field = strings.TrimSpace(field)
+		if field != "" {
+			tokens = append(tokens, field)
+		}
+	}
+	return tokens, nil
 }
 
 // Helpers
@@ -205,16 +783,60 @@ func wordListUniqRunes(words WordSet) RuneSet {
 	return allRunesMap
 }
 
+// Apply the -invalid policy to a folded token, expanding it into zero or
+// more whitelisted words:
+//   - "drop" keeps only whitelisted runes, concatenating what remains
+//   - "split" breaks the token into separate words at each disallowed rune
+//   - "skip-word" discards the whole token if any rune is disallowed
+func applyInvalidPolicy(token string, whitemap map[rune]struct{}, policy string) []Word {
+	switch policy {
+	case "split":
+		var words []Word
+		var current []rune
+		for _, r := range token {
+			if _, ok := whitemap[r]; ok {
+				current = append(current, r)
+				continue
+			}
+			if len(current) > 0 {
+				words = append(words, Word(current))
+				current = nil
+			}
+		}
+		if len(current) > 0 {
+			words = append(words, Word(current))
+		}
+		return words
+	case "skip-word":
+		for _, r := range token {
+			if _, ok := whitemap[r]; !ok {
+				return nil
+			}
+		}
+		return []Word{Word(token)}
+	default: // "drop"
+		runes := make([]rune, 0, len(token))
+		for _, r := range token {
+			if _, ok := whitemap[r]; ok {
+				runes = append(runes, r)
+			}
+		}
+		return []Word{Word(runes)}
+	}
+}
+
 // Read unique words from a file given a set of accepted characters
-func readUniqWordsFromFile(path, whitelist string) (WordSet, error) {
+func readUniqWordsFromFile(path, whitelist string, tokenizer Tokenizer, invalidPolicy string) (WordSet, error) {
 	file, err := os.Open(path)
 	if err != nil {
 		return nil, err
 	}
 	defer file.Close()
 
-	scanner := bufio.NewScanner(file)
-	scanner.Split(bufio.ScanWords)
+	tokens, err := tokenizer.Tokens(file)
+	if err != nil {
+		return nil, err
+	}
 
 	whitemap := make(map[rune]struct{})
 	for _, r := range whitelist {
@@ -222,19 +844,16 @@ func readUniqWordsFromFile(path, whitelist string) (WordSet, error) {
 	}
 
 	wordSet := WordSet{}
-	for scanner.Scan() {
-		str := scanner.Text()
-		if str != "" {
-			str = strings.ToLower(str)
-			runes := make([]rune, 0, len(str))
-			for _, r := range str {
-				if _, ok := whitemap[r]; ok {
-					runes = append(runes, r)
-				}
+	for _, token := range tokens {
+		if token == "" {
+			continue
+		}
+		for _, word := range applyInvalidPolicy(token, whitemap, invalidPolicy) {
+			if word != "" {
+				wordSet[word] = struct{}{}
 			}
-			wordSet[Word(runes)] = struct{}{}
 		}
 	}
 
-	return wordSet, scanner.Err()
+	return wordSet, nil
 }
@@ -0,0 +1,348 @@
+// Copyright © 2015 Jussi Rajala <zemm@iki.fi>
+//
+// This work is free. You can redistribute it and/or modify it under the
+// terms of the Do What The Fuck You Want To Public License, Version 2,
+// as published by Sam Hocevar. See the COPYING file for more details.
+
+package main
+
+import (
+	"math/rand"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// syntheticWordSet builds a reproducible WordSet of n words of up to
+// maxLen runes drawn from alphabet, standing in for a real-world corpus in
+// benchmarks so they don't depend on a text file being present on disk.
+func syntheticWordSet(n int, alphabet string, maxLen int, seed int64) WordSet {
+	rng := rand.New(rand.NewSource(seed))
+	letters := []rune(alphabet)
+	words := make(WordSet, n)
+	for len(words) < n {
+		buf := make([]rune, 2+rng.Intn(maxLen-1))
+		for i := range buf {
+			buf[i] = letters[rng.Intn(len(letters))]
+		}
+		words[Word(buf)] = struct{}{}
+	}
+	return words
+}
+
+func benchmarkTopPairsAndWeight(b *testing.B, workers int) {
+	words := syntheticWordSet(4000, "abcdefghijklmnopqrstuvwzyxåäö", 10, 1)
+	wsm := NewWordSetMasks(words)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		wsm.topPairsAndWeight(workers)
+	}
+}
+
+func BenchmarkTopPairsAndWeight_1(b *testing.B) { benchmarkTopPairsAndWeight(b, 1) }
+func BenchmarkTopPairsAndWeight_2(b *testing.B) { benchmarkTopPairsAndWeight(b, 2) }
+func BenchmarkTopPairsAndWeight_4(b *testing.B) { benchmarkTopPairsAndWeight(b, 4) }
+
+func BenchmarkTopPairsAndWeight_GOMAXPROCS(b *testing.B) {
+	benchmarkTopPairsAndWeight(b, runtime.GOMAXPROCS(0))
+}
+
+// maskIDFor returns the MaskID that word was grouped under, failing the test
+// if word isn't present in wsm at all.
+func maskIDFor(t *testing.T, wsm *WordSetMasks, word Word) MaskID {
+	t.Helper()
+	for id, words := range wsm.wordsByMasks {
+		for _, w := range words {
+			if w == word {
+				return id
+			}
+		}
+	}
+	t.Fatalf("word %q not found in wsm.wordsByMasks", word)
+	return 0
+}
+
+// TestWordSetMasksLargeAlphabet guards against the >64-rune truncation bug:
+// a corpus spanning more than 64 distinct runes must carry every bit across
+// the resulting multi-word Mask, not just the first 64.
+func TestWordSetMasksLargeAlphabet(t *testing.T) {
+	const base = rune(0x4e00) // CJK Unified Ideographs, clear of any Latin word elsewhere in the suite
+	runes := make([]rune, 70)
+	for i := range runes {
+		runes[i] = base + rune(i)
+	}
+
+	wordA := Word(string(runes[0:64]))  // bits 0-63: exactly fills the first mask word
+	wordB := Word(string(runes[64:70])) // bits 64-69: only representable in a second mask word
+	wordC := Word(string(runes[0:1]))   // strict subset of wordA
+	wordE := Word(string(runes[60:68])) // straddles the 64-bit boundary
+
+	words := WordSet{wordA: {}, wordB: {}, wordC: {}, wordE: {}}
+	wsm := NewWordSetMasks(words)
+
+	maskA := maskIDFor(t, wsm, wordA)
+	maskB := maskIDFor(t, wsm, wordB)
+
+	if weight := wsm.maskTable.weight(maskA); weight != 64 {
+		t.Errorf("weight(wordA) = %d, want 64", weight)
+	}
+	if weight := wsm.maskTable.weight(maskB); weight != 6 {
+		t.Errorf("weight(wordB) = %d, want 6", weight)
+	}
+
+	// A single uint64 mask would silently drop every bit past index 63, so
+	// this union is the crux of the regression test: it must see all 70.
+	unionWeight := wsm.maskTable.weight(wsm.maskTable.union(maskA, maskB))
+	if unionWeight != 70 {
+		t.Errorf("weight(wordA ∪ wordB) = %d, want 70", unionWeight)
+	}
+
+	topPairs, topWeight := wsm.topPairsAndWeight(0)
+	if topWeight != 70 {
+		t.Fatalf("topPairsAndWeight weight = %d, want 70", topWeight)
+	}
+	if got := wsm.maskPairsToWordPairs(topPairs); len(got) != 1 || !isUnorderedWordPair(got[0], wordA, wordB) {
+		t.Errorf("topPairsAndWeight pairs = %v, want a single pair of {%q, %q} in either order", got, wordA, wordB)
+	}
+
+	scored := wsm.TopKPairs(1)
+	if len(scored) != 1 || scored[0].weight != 70 {
+		t.Fatalf("TopKPairs(1) = %v, want a single pair of weight 70", scored)
+	}
+	if got := wsm.maskPairsToWordPairs([]WordMaskPair{scored[0].pair}); len(got) != 1 || !isUnorderedWordPair(got[0], wordA, wordB) {
+		t.Errorf("TopKPairs(1) pair = %v, want a single pair of {%q, %q} in either order", got, wordA, wordB)
+	}
+}
+
+// isUnorderedWordPair reports whether pair holds wantA and wantB in either
+// order. Mask iteration order (a plain Go map) decides which mask lands in
+// pair.a vs pair.b, so callers must not assert a specific side.
+func isUnorderedWordPair(pair WordPair, wantA, wantB Word) bool {
+	return (pair.a == wantA && pair.b == wantB) || (pair.a == wantB && pair.b == wantA)
+}
+
+// wordTupleWeight returns the number of distinct runes across all of words.
+func wordTupleWeight(words []Word) WordMaskWeight {
+	runes := make(RuneSet)
+	for _, word := range words {
+		for _, r := range word {
+			runes[r] = struct{}{}
+		}
+	}
+	return WordMaskWeight(len(runes))
+}
+
+// bruteForceMaxTupleWeight finds the largest wordTupleWeight over every
+// distinct size-n combination of corpus, by brute-force enumeration.
+func bruteForceMaxTupleWeight(corpus []Word, n int) WordMaskWeight {
+	best := WordMaskWeight(0)
+	combo := make([]Word, 0, n)
+	var recurse func(start int)
+	recurse = func(start int) {
+		if len(combo) == n {
+			if weight := wordTupleWeight(combo); weight > best {
+				best = weight
+			}
+			return
+		}
+		for i := start; i <= len(corpus)-(n-len(combo)); i++ {
+			combo = append(combo, corpus[i])
+			recurse(i + 1)
+			combo = combo[:len(combo)-1]
+		}
+	}
+	recurse(0)
+	return best
+}
+
+// TestTopTuplesAndWeightMatchesBruteForce checks the meet-in-the-middle
+// branch-and-bound search (dedup-by-union-mask, sorted right-half pruning)
+// against a naive brute-force N-tuple search on a small corpus.
+func TestTopTuplesAndWeightMatchesBruteForce(t *testing.T) {
+	corpus := []Word{"cat", "dog", "fish", "bird", "frog", "ant"}
+	words := WordSet{}
+	for _, w := range corpus {
+		words[w] = struct{}{}
+	}
+	wsm := NewWordSetMasks(words)
+
+	for _, n := range []int{2, 3, 4} {
+		wantWeight := bruteForceMaxTupleWeight(corpus, n)
+		_, tupleWords, gotWeight := wsm.TopTuplesAndWeight(n)
+		if gotWeight != wantWeight {
+			t.Errorf("n=%d: TopTuplesAndWeight weight = %d, want %d (brute force)", n, gotWeight, wantWeight)
+		}
+		if len(tupleWords) == 0 {
+			t.Errorf("n=%d: TopTuplesAndWeight returned no tuples", n)
+		}
+		for _, tuple := range tupleWords {
+			if len(tuple) != n {
+				t.Errorf("n=%d: tuple %v has %d words, want %d", n, tuple, len(tuple), n)
+			}
+			if weight := wordTupleWeight(tuple); weight != wantWeight {
+				t.Errorf("n=%d: returned tuple %v has weight %d, want %d", n, tuple, weight, wantWeight)
+			}
+			seen := make(map[Word]struct{}, len(tuple))
+			for _, word := range tuple {
+				if _, ok := seen[word]; ok {
+					t.Errorf("n=%d: tuple %v repeats word %q, want %d distinct words", n, tuple, word, n)
+				}
+				seen[word] = struct{}{}
+			}
+		}
+	}
+}
+
+// TestTopTuplesAndWeightNTooLargeForCorpus checks that asking for more words
+// than the corpus has doesn't panic and simply reports no tuples found.
+func TestTopTuplesAndWeightNTooLargeForCorpus(t *testing.T) {
+	corpus := []Word{"cat", "dog", "fish"}
+	words := WordSet{}
+	for _, w := range corpus {
+		words[w] = struct{}{}
+	}
+	wsm := NewWordSetMasks(words)
+
+	tuples, tupleWords, weight := wsm.TopTuplesAndWeight(10)
+	if len(tuples) != 0 || len(tupleWords) != 0 || weight != 0 {
+		t.Errorf("TopTuplesAndWeight(10) with a 3-word corpus = (%v, %v, %d), want (nil, nil, 0)", tuples, tupleWords, weight)
+	}
+}
+
+// bruteForcePairWeights returns the weight of every distinct word pair in
+// corpus, sorted descending, by naive enumeration.
+func bruteForcePairWeights(corpus []Word) []WordMaskWeight {
+	var weights []WordMaskWeight
+	for i := 0; i < len(corpus); i++ {
+		for j := i + 1; j < len(corpus); j++ {
+			weights = append(weights, wordTupleWeight([]Word{corpus[i], corpus[j]}))
+		}
+	}
+	sort.Slice(weights, func(i, j int) bool { return weights[i] > weights[j] })
+	return weights
+}
+
+// TestTopKPairsMatchesBruteForce checks the bounded min-heap search (with
+// its heapMin pruning) against a naive brute-force pair search.
+func TestTopKPairsMatchesBruteForce(t *testing.T) {
+	corpus := []Word{"a", "b", "c", "ab", "bc", "abc"}
+	words := WordSet{}
+	for _, w := range corpus {
+		words[w] = struct{}{}
+	}
+	wsm := NewWordSetMasks(words)
+
+	wantWeights := bruteForcePairWeights(corpus)
+	const k = 3
+	scored := wsm.TopKPairs(k)
+	if len(scored) != k {
+		t.Fatalf("TopKPairs(%d) returned %d pairs, want %d", k, len(scored), k)
+	}
+	for i, s := range scored {
+		if s.weight != wantWeights[i] {
+			t.Errorf("TopKPairs(%d)[%d].weight = %d, want %d (brute force)", k, i, s.weight, wantWeights[i])
+		}
+		if i > 0 && s.weight > scored[i-1].weight {
+			t.Errorf("TopKPairs(%d) not sorted descending at index %d", k, i)
+		}
+	}
+}
+
+// TestTopKPairsKLargerThanAvailable checks that asking for more pairs than
+// exist returns every available pair instead of panicking or padding.
+func TestTopKPairsKLargerThanAvailable(t *testing.T) {
+	corpus := []Word{"a", "b", "c", "ab", "bc", "abc"}
+	words := WordSet{}
+	for _, w := range corpus {
+		words[w] = struct{}{}
+	}
+	wsm := NewWordSetMasks(words)
+
+	want := bruteForcePairWeights(corpus)
+	scored := wsm.TopKPairs(100)
+	if len(scored) != len(want) {
+		t.Fatalf("TopKPairs(100) returned %d pairs, want %d (all available)", len(scored), len(want))
+	}
+	for i, s := range scored {
+		if s.weight != want[i] {
+			t.Errorf("TopKPairs(100)[%d].weight = %d, want %d (brute force)", i, s.weight, want[i])
+		}
+	}
+}
+
+// TestTopKPairsSingleWordCorpus checks that a corpus with no possible pair
+// returns zero results rather than panicking.
+func TestTopKPairsSingleWordCorpus(t *testing.T) {
+	words := WordSet{"only": {}}
+	wsm := NewWordSetMasks(words)
+
+	if scored := wsm.TopKPairs(5); len(scored) != 0 {
+		t.Errorf("TopKPairs(5) on a single-word corpus = %v, want none", scored)
+	}
+}
+
+// TestApplyInvalidPolicy checks each -invalid policy's handling of a token
+// that contains both whitelisted and disallowed runes, and of tokens that
+// are entirely clean or entirely disallowed.
+func TestApplyInvalidPolicy(t *testing.T) {
+	whitemap := make(map[rune]struct{})
+	for _, r := range "abcdefghijklmnopqrstuvwxyz" {
+		whitemap[r] = struct{}{}
+	}
+
+	tests := []struct {
+		name   string
+		token  string
+		policy string
+		want   []Word
+	}{
+		{"drop removes disallowed runes", "it's", "drop", []Word{"its"}},
+		{"split breaks at disallowed runes", "it's", "split", []Word{"it", "s"}},
+		{"skip-word discards token with any disallowed rune", "it's", "skip-word", nil},
+		{"drop keeps a clean token unchanged", "cat", "drop", []Word{"cat"}},
+		{"split on a clean token yields it whole", "cat", "split", []Word{"cat"}},
+		{"skip-word on a clean token keeps it", "cat", "skip-word", []Word{"cat"}},
+		{"split on an all-disallowed token yields nothing", "123", "split", nil},
+		{"drop on an all-disallowed token yields an empty word", "123", "drop", []Word{""}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got := applyInvalidPolicy(tc.token, whitemap, tc.policy)
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("applyInvalidPolicy(%q, _, %q) = %v, want %v", tc.token, tc.policy, got, tc.want)
+			}
+		})
+	}
+}
+
+// TestUnicodeTokenizerCaseFoldingDivergesFromASCII checks that the Unicode
+// tokenizer's locale-independent folding actually differs from ASCII
+// strings.ToLower on input where the two are known to disagree: U+0130
+// LATIN CAPITAL LETTER I WITH DOT ABOVE has no simple 1:1 ASCII lowercase
+// mapping, so full Unicode case folding expands it to "i" + a combining
+// dot above, while ASCII folding collapses it to a bare "i".
+func TestUnicodeTokenizerCaseFoldingDivergesFromASCII(t *testing.T) {
+	const input = "İstanbul"
+
+	asciiTokens, err := WhitespaceTokenizer{}.Tokens(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("WhitespaceTokenizer.Tokens: %v", err)
+	}
+	unicodeTokens, err := UnicodeTokenizer{split: splitWhitespace}.Tokens(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("UnicodeTokenizer.Tokens: %v", err)
+	}
+
+	if len(asciiTokens) != 1 || len(unicodeTokens) != 1 {
+		t.Fatalf("got %d ASCII tokens and %d Unicode tokens, want 1 each", len(asciiTokens), len(unicodeTokens))
+	}
+	if asciiTokens[0] != "istanbul" {
+		t.Errorf("ASCII folding of %q = %q, want %q", input, asciiTokens[0], "istanbul")
+	}
+	if unicodeTokens[0] == asciiTokens[0] {
+		t.Errorf("Unicode folding of %q = %q, want it to diverge from the ASCII result %q", input, unicodeTokens[0], asciiTokens[0])
+	}
+}